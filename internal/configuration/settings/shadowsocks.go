@@ -0,0 +1,124 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/qdm12/gosettings"
+	"github.com/qdm12/gosettings/reader"
+	"github.com/qdm12/gosettings/validate"
+	"github.com/qdm12/gotree"
+)
+
+// ErrShadowsocksCipherNotValid is returned when the Shadowsocks cipher
+// is not one of the supported ciphers.
+var ErrShadowsocksCipherNotValid = errors.New("shadowsocks cipher is not valid")
+
+// Shadowsocks contains settings to configure the Shadowsocks proxy.
+type Shadowsocks struct {
+	// Enabled is true if the Shadowsocks proxy server should run,
+	// and false otherwise. It cannot be nil in the internal state.
+	Enabled *bool
+	// ListeningAddress is the listening address of the Shadowsocks
+	// proxy server. It cannot be the empty string in the internal state.
+	ListeningAddress string
+	// Password is the password to use for the Shadowsocks proxy.
+	// It cannot be nil in the internal state.
+	Password *string
+	// Cipher is the cipher to use for the Shadowsocks proxy.
+	// It defaults to chacha20-ietf-poly1305 if left unset.
+	Cipher string
+	// LogAddresses is true if the Shadowsocks proxy server should log
+	// the clients and destinations addresses. It cannot be nil in the
+	// internal state.
+	LogAddresses *bool
+}
+
+func (s Shadowsocks) validate() (err error) {
+	// Do not validate password
+	err = validate.ListeningAddress(s.ListeningAddress, os.Getuid())
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrServerAddressNotValid, s.ListeningAddress)
+	}
+
+	if _, ok := shadowsocksCiphers[s.Cipher]; !ok {
+		return fmt.Errorf("%w: %s", ErrShadowsocksCipherNotValid, s.Cipher)
+	}
+
+	return nil
+}
+
+var shadowsocksCiphers = map[string]struct{}{
+	"chacha20-ietf-poly1305": {},
+	"aes-128-gcm":            {},
+	"aes-192-gcm":            {},
+	"aes-256-gcm":            {},
+}
+
+func (s *Shadowsocks) copy() (copied Shadowsocks) {
+	return Shadowsocks{
+		Enabled:          gosettings.CopyPointer(s.Enabled),
+		ListeningAddress: s.ListeningAddress,
+		Password:         gosettings.CopyPointer(s.Password),
+		Cipher:           s.Cipher,
+		LogAddresses:     gosettings.CopyPointer(s.LogAddresses),
+	}
+}
+
+// overrideWith overrides fields of the receiver settings object
+// with any field set in the other settings.
+func (s *Shadowsocks) overrideWith(other Shadowsocks) {
+	s.Enabled = gosettings.OverrideWithPointer(s.Enabled, other.Enabled)
+	s.ListeningAddress = gosettings.OverrideWithComparable(s.ListeningAddress, other.ListeningAddress)
+	s.Password = gosettings.OverrideWithPointer(s.Password, other.Password)
+	s.Cipher = gosettings.OverrideWithComparable(s.Cipher, other.Cipher)
+	s.LogAddresses = gosettings.OverrideWithPointer(s.LogAddresses, other.LogAddresses)
+}
+
+func (s *Shadowsocks) setDefaults() {
+	s.Enabled = gosettings.DefaultPointer(s.Enabled, false)
+	s.ListeningAddress = gosettings.DefaultComparable(s.ListeningAddress, ":8388")
+	s.Password = gosettings.DefaultPointer(s.Password, "")
+	s.Cipher = gosettings.DefaultComparable(s.Cipher, "chacha20-ietf-poly1305")
+	s.LogAddresses = gosettings.DefaultPointer(s.LogAddresses, false)
+}
+
+func (s Shadowsocks) String() string {
+	return s.toLinesNode().String()
+}
+
+func (s Shadowsocks) toLinesNode() (node *gotree.Node) {
+	node = gotree.New("Shadowsocks server settings:")
+	node.Appendf("Enabled: %s", gosettings.BoolToYesNo(s.Enabled))
+	if !*s.Enabled {
+		return node
+	}
+
+	node.Appendf("Listening address: %s", s.ListeningAddress)
+	node.Appendf("Password: %s", gosettings.ObfuscateKey(*s.Password))
+	node.Appendf("Cipher: %s", s.Cipher)
+	node.Appendf("Log addresses: %s", gosettings.BoolToYesNo(s.LogAddresses))
+
+	return node
+}
+
+func (s *Shadowsocks) read(r *reader.Reader) (err error) {
+	s.Enabled, err = r.BoolPtr("SHADOWSOCKS")
+	if err != nil {
+		return err
+	}
+
+	s.ListeningAddress = r.String("SHADOWSOCKS_LISTENING_ADDRESS")
+
+	s.Password = r.Get("SHADOWSOCKS_PASSWORD", reader.ForceLowercase(false))
+
+	s.Cipher = r.String("SHADOWSOCKS_CIPHER")
+
+	s.LogAddresses, err = r.BoolPtr("SHADOWSOCKS_LOG_ADDRESSES")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
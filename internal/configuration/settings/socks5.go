@@ -1,8 +1,11 @@
 package settings
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/qdm12/gosettings"
@@ -11,6 +14,14 @@ import (
 	"github.com/qdm12/gotree"
 )
 
+// ErrAccessCIDRNotValid is returned when a Socks5 access control
+// CIDR cannot be parsed.
+var ErrAccessCIDRNotValid = errors.New("access control CIDR is not valid")
+
+// ErrLogFormatNotValid is returned when the Socks5 access log format
+// is neither "text" nor "json".
+var ErrLogFormatNotValid = errors.New("log format is not valid")
+
 // Socks5 contains settings to configure the Socks5 proxy.
 type Socks5 struct {
 	// User is the username to use for the Socks5 proxy.
@@ -30,6 +41,46 @@ type Socks5 struct {
 	// ReadTimeout is the Socks5 read timeout duration
 	// of the Socks5 server. It defaults to 3 seconds if left unset.
 	ReadTimeout time.Duration
+	// AcceptProxyProtocol is true if the Socks5 server should expect
+	// a PROXY protocol v1 or v2 header at the start of each TCP
+	// connection, such as when it sits behind a L4 load balancer or
+	// another proxy. It defaults to false if left unset. It cannot
+	// be nil in the internal state.
+	AcceptProxyProtocol *bool
+	// Access contains the CIDR-based allow/deny rules applied to
+	// Socks5 clients and their requested destinations.
+	Access Socks5Access
+	// LogConnections is true if each completed Socks5 session should
+	// be logged as a single access log event. It cannot be nil in
+	// the internal state.
+	LogConnections *bool
+	// LogFormat is the format used for the access log events, either
+	// "text" or "json". It defaults to "text" if left unset.
+	LogFormat string
+	// TrustedProxies is a list of CIDRs of front-end proxies allowed
+	// to report the real client IP through an X-Real-IP or Forwarded
+	// preamble line tunneled over the raw TCP stream. It is ignored
+	// for connections coming from any other source.
+	TrustedProxies []string
+}
+
+// Socks5Access contains CIDR-based allow/deny rules for the Socks5 proxy.
+// Rules are evaluated using longest prefix match, and an explicit deny
+// always wins over an allow matching at the same prefix length.
+type Socks5Access struct {
+	// AllowedClients is a list of CIDRs allowed to connect to the
+	// Socks5 proxy. If empty, all clients are allowed unless denied.
+	AllowedClients []string
+	// DeniedClients is a list of CIDRs denied from connecting to the
+	// Socks5 proxy.
+	DeniedClients []string
+	// AllowedDestinations is a list of CIDRs the Socks5 proxy is
+	// allowed to connect out to. If empty, all destinations are
+	// allowed unless denied.
+	AllowedDestinations []string
+	// DeniedDestinations is a list of CIDRs the Socks5 proxy is
+	// denied from connecting out to.
+	DeniedDestinations []string
 }
 
 func (h Socks5) validate() (err error) {
@@ -39,16 +90,59 @@ func (h Socks5) validate() (err error) {
 		return fmt.Errorf("%w: %s", ErrServerAddressNotValid, h.ListeningAddress)
 	}
 
+	err = h.Access.validate()
+	if err != nil {
+		return err
+	}
+
+	if h.LogFormat != "text" && h.LogFormat != "json" {
+		return fmt.Errorf("%w: %s", ErrLogFormatNotValid, h.LogFormat)
+	}
+
+	for _, cidr := range h.TrustedProxies {
+		_, _, err = net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrAccessCIDRNotValid, cidr)
+		}
+	}
+
+	return nil
+}
+
+func (a Socks5Access) validate() (err error) {
+	cidrLists := [][]string{a.AllowedClients, a.DeniedClients, a.AllowedDestinations, a.DeniedDestinations}
+	for _, cidrs := range cidrLists {
+		for _, cidr := range cidrs {
+			_, _, err = net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrAccessCIDRNotValid, cidr)
+			}
+		}
+	}
 	return nil
 }
 
 func (h *Socks5) copy() (copied Socks5) {
 	return Socks5{
-		User:              gosettings.CopyPointer(h.User),
-		Password:          gosettings.CopyPointer(h.Password),
-		ListeningAddress:  h.ListeningAddress,
-		Enabled:           gosettings.CopyPointer(h.Enabled),
-		ReadTimeout:       h.ReadTimeout,
+		User:                gosettings.CopyPointer(h.User),
+		Password:            gosettings.CopyPointer(h.Password),
+		ListeningAddress:    h.ListeningAddress,
+		Enabled:             gosettings.CopyPointer(h.Enabled),
+		ReadTimeout:         h.ReadTimeout,
+		AcceptProxyProtocol: gosettings.CopyPointer(h.AcceptProxyProtocol),
+		Access:              h.Access.copy(),
+		LogConnections:      gosettings.CopyPointer(h.LogConnections),
+		LogFormat:           h.LogFormat,
+		TrustedProxies:      gosettings.CopySlice(h.TrustedProxies),
+	}
+}
+
+func (a Socks5Access) copy() (copied Socks5Access) {
+	return Socks5Access{
+		AllowedClients:      gosettings.CopySlice(a.AllowedClients),
+		DeniedClients:       gosettings.CopySlice(a.DeniedClients),
+		AllowedDestinations: gosettings.CopySlice(a.AllowedDestinations),
+		DeniedDestinations:  gosettings.CopySlice(a.DeniedDestinations),
 	}
 }
 
@@ -61,6 +155,18 @@ func (h *Socks5) overrideWith(other Socks5) {
 	h.ListeningAddress = gosettings.OverrideWithComparable(h.ListeningAddress, other.ListeningAddress)
 	h.Enabled = gosettings.OverrideWithPointer(h.Enabled, other.Enabled)
 	h.ReadTimeout = gosettings.OverrideWithComparable(h.ReadTimeout, other.ReadTimeout)
+	h.AcceptProxyProtocol = gosettings.OverrideWithPointer(h.AcceptProxyProtocol, other.AcceptProxyProtocol)
+	h.Access.overrideWith(other.Access)
+	h.LogConnections = gosettings.OverrideWithPointer(h.LogConnections, other.LogConnections)
+	h.LogFormat = gosettings.OverrideWithComparable(h.LogFormat, other.LogFormat)
+	h.TrustedProxies = gosettings.OverrideWithSlice(h.TrustedProxies, other.TrustedProxies)
+}
+
+func (a *Socks5Access) overrideWith(other Socks5Access) {
+	a.AllowedClients = gosettings.OverrideWithSlice(a.AllowedClients, other.AllowedClients)
+	a.DeniedClients = gosettings.OverrideWithSlice(a.DeniedClients, other.DeniedClients)
+	a.AllowedDestinations = gosettings.OverrideWithSlice(a.AllowedDestinations, other.AllowedDestinations)
+	a.DeniedDestinations = gosettings.OverrideWithSlice(a.DeniedDestinations, other.DeniedDestinations)
 }
 
 func (h *Socks5) setDefaults() {
@@ -70,6 +176,9 @@ func (h *Socks5) setDefaults() {
 	h.Enabled = gosettings.DefaultPointer(h.Enabled, false)
 	const defaultReadTimeout = 3 * time.Second
 	h.ReadTimeout = gosettings.DefaultComparable(h.ReadTimeout, defaultReadTimeout)
+	h.AcceptProxyProtocol = gosettings.DefaultPointer(h.AcceptProxyProtocol, false)
+	h.LogConnections = gosettings.DefaultPointer(h.LogConnections, false)
+	h.LogFormat = gosettings.DefaultComparable(h.LogFormat, "text")
 }
 
 func (h Socks5) String() string {
@@ -87,10 +196,34 @@ func (h Socks5) toLinesNode() (node *gotree.Node) {
 	node.Appendf("User: %s", *h.User)
 	node.Appendf("Password: %s", gosettings.ObfuscateKey(*h.Password))
 	node.Appendf("Read timeout: %s", h.ReadTimeout)
+	node.Appendf("Accept PROXY protocol: %s", gosettings.BoolToYesNo(h.AcceptProxyProtocol))
+	node.Appendf("Allowed clients: %s", commaJoinOrAll(h.Access.AllowedClients))
+	node.Appendf("Denied clients: %s", commaJoinOrNone(h.Access.DeniedClients))
+	node.Appendf("Allowed destinations: %s", commaJoinOrAll(h.Access.AllowedDestinations))
+	node.Appendf("Denied destinations: %s", commaJoinOrNone(h.Access.DeniedDestinations))
+	node.Appendf("Log connections: %s", gosettings.BoolToYesNo(h.LogConnections))
+	if *h.LogConnections {
+		node.Appendf("Log format: %s", h.LogFormat)
+	}
+	node.Appendf("Trusted proxies: %s", commaJoinOrNone(h.TrustedProxies))
 
 	return node
 }
 
+func commaJoinOrAll(cidrs []string) string {
+	if len(cidrs) == 0 {
+		return "all"
+	}
+	return strings.Join(cidrs, ", ")
+}
+
+func commaJoinOrNone(cidrs []string) string {
+	if len(cidrs) == 0 {
+		return "none"
+	}
+	return strings.Join(cidrs, ", ")
+}
+
 func (h *Socks5) read(r *reader.Reader) (err error) {
 	h.User = r.Get("SOCKS5SERVER_USER",
 		// reader.RetroKeys("PROXY_USER", "TINYPROXY_USER"),
@@ -110,6 +243,25 @@ func (h *Socks5) read(r *reader.Reader) (err error) {
 		return err
 	}
 
+	h.AcceptProxyProtocol, err = r.BoolPtr("SOCKS5SERVER_ACCEPT_PROXY_PROTOCOL")
+	if err != nil {
+		return err
+	}
+
+	h.Access.AllowedClients = r.CSV("SOCKS5SERVER_ALLOWED_CLIENTS")
+	h.Access.DeniedClients = r.CSV("SOCKS5SERVER_DENIED_CLIENTS")
+	h.Access.AllowedDestinations = r.CSV("SOCKS5SERVER_ALLOWED_DESTINATIONS")
+	h.Access.DeniedDestinations = r.CSV("SOCKS5SERVER_DENIED_DESTINATIONS")
+
+	h.LogConnections, err = r.BoolPtr("SOCKS5SERVER_LOG_CONNECTIONS")
+	if err != nil {
+		return err
+	}
+
+	h.LogFormat = r.String("SOCKS5SERVER_LOG_FORMAT")
+
+	h.TrustedProxies = r.CSV("SOCKS5SERVER_TRUSTED_PROXIES")
+
 	return nil
 }
 
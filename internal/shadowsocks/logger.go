@@ -0,0 +1,14 @@
+package shadowsocks
+
+// Logger is the logger interface required by the Shadowsocks server.
+// It matches github.com/qdm12/ss-server/pkg/tcpudp.Logger so it can be
+// passed straight through to the underlying server.
+type Logger interface {
+	infoErrorer
+	Debug(s string)
+}
+
+type infoErrorer interface {
+	Info(s string)
+	Error(s string)
+}
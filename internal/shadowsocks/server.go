@@ -0,0 +1,56 @@
+package shadowsocks
+
+import (
+	"context"
+	"sync"
+
+	ss "github.com/qdm12/ss-server/pkg/tcpudp"
+)
+
+type Server struct {
+	address      string
+	password     string
+	cipher       string
+	logAddresses bool
+	logger       Logger
+	internalWG   *sync.WaitGroup
+}
+
+func New(ctx context.Context, address string, logger Logger,
+	password, cipher string, logAddresses bool,
+) *Server {
+	wg := &sync.WaitGroup{}
+	return &Server{
+		address:      address,
+		password:     password,
+		cipher:       cipher,
+		logAddresses: logAddresses,
+		logger:       logger,
+		internalWG:   wg,
+	}
+}
+
+func (s *Server) Run(ctx context.Context, errorCh chan<- error) {
+	server, err := ss.NewServer(ss.Settings{
+		Address:      &s.address,
+		CipherName:   s.cipher,
+		Password:     &s.password,
+		LogAddresses: &s.logAddresses,
+	}, s.logger)
+	if err != nil {
+		s.logger.Error("failed creating shadowsocks server: " + err.Error())
+		errorCh <- err
+		return
+	}
+
+	s.logger.Info("listening on " + s.address)
+	// Listen returns as soon as ctx is canceled, there is no separate
+	// Stop method to shut the server down.
+	err = server.Listen(ctx)
+
+	if err != nil && ctx.Err() == nil {
+		errorCh <- err
+	} else {
+		errorCh <- nil
+	}
+}
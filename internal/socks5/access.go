@@ -0,0 +1,169 @@
+package socks5
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/qdm12/gluetun/internal/configuration/settings"
+)
+
+// cidrNode is a node of a binary radix tree keyed on address bits,
+// up to 128 bits since every IP is normalized to its 16 byte form.
+type cidrNode struct {
+	children [2]*cidrNode
+	hasAllow bool
+	hasDeny  bool
+}
+
+// cidrTree is a longest-prefix-match tree of CIDR allow/deny rules.
+type cidrTree struct {
+	root *cidrNode
+}
+
+func newCIDRTree() *cidrTree {
+	return &cidrTree{root: &cidrNode{}}
+}
+
+func (t *cidrTree) insert(network *net.IPNet, allow bool) {
+	ip, prefixLength := normalizeNetwork(network)
+	node := t.root
+	for i := 0; i < prefixLength; i++ {
+		bit := ipBit(ip, i)
+		if node.children[bit] == nil {
+			node.children[bit] = &cidrNode{}
+		}
+		node = node.children[bit]
+	}
+	if allow {
+		node.hasAllow = true
+	} else {
+		node.hasDeny = true
+	}
+}
+
+// lookup returns the rule of the longest matching prefix, preferring
+// deny over allow when both are set on the same node. matched is false
+// if no rule along the path matched.
+func (t *cidrTree) lookup(ip net.IP) (allow, matched bool) {
+	ip = ip.To16()
+	node := t.root
+	for i := 0; ; i++ {
+		if node.hasDeny {
+			allow, matched = false, true
+		} else if node.hasAllow {
+			allow, matched = true, true
+		}
+
+		const ipv6Bits = 128
+		if i == ipv6Bits {
+			break
+		}
+
+		next := node.children[ipBit(ip, i)]
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return allow, matched
+}
+
+func normalizeNetwork(network *net.IPNet) (ip net.IP, prefixLength int) {
+	ones, bits := network.Mask.Size()
+	const ipv4Bits = 32
+	const ipv4MappedOffset = 96
+	if bits == ipv4Bits {
+		return network.IP.To16(), ones + ipv4MappedOffset
+	}
+	return network.IP.To16(), ones
+}
+
+func ipBit(ip net.IP, i int) int {
+	byteIndex := i / 8        //nolint:mnd
+	bitIndex := uint(7 - i%8) //nolint:mnd
+	return int((ip[byteIndex] >> bitIndex) & 1)
+}
+
+// accessControl enforces the Socks5.Access CIDR allow/deny rules
+// against connecting clients and the destinations they request.
+type accessControl struct {
+	clients                *cidrTree
+	hasAllowedClients      bool
+	destinations           *cidrTree
+	hasAllowedDestinations bool
+}
+
+func newAccessControl(settings settings.Socks5Access) (access *accessControl, err error) {
+	access = &accessControl{
+		clients:      newCIDRTree(),
+		destinations: newCIDRTree(),
+	}
+
+	if err := insertAll(access.clients, settings.AllowedClients, true); err != nil {
+		return nil, err
+	}
+	if err := insertAll(access.clients, settings.DeniedClients, false); err != nil {
+		return nil, err
+	}
+	if err := insertAll(access.destinations, settings.AllowedDestinations, true); err != nil {
+		return nil, err
+	}
+	if err := insertAll(access.destinations, settings.DeniedDestinations, false); err != nil {
+		return nil, err
+	}
+
+	access.hasAllowedClients = len(settings.AllowedClients) > 0
+	access.hasAllowedDestinations = len(settings.AllowedDestinations) > 0
+
+	return access, nil
+}
+
+// parseCIDRs parses a list of CIDR strings into *net.IPNet values,
+// such as the TrustedProxies list used by the forwarded header listener.
+func parseCIDRs(cidrs []string) (networks []*net.IPNet, err error) {
+	networks = make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing CIDR %s: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func insertAll(tree *cidrTree, cidrs []string, allow bool) (err error) {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("parsing CIDR %s: %w", cidr, err)
+		}
+		tree.insert(network, allow)
+	}
+	return nil
+}
+
+func (a *accessControl) allowClient(ip net.IP) bool {
+	return allow(a.clients, a.hasAllowedClients, ip)
+}
+
+// allowDestination checks ip against the destination CIDR rules. ip is
+// nil whenever the client used ATYP domain and the raw request only
+// carries a hostname, which the CIDR tree cannot match against; that
+// case is treated the same as "no rule matched".
+func (a *accessControl) allowDestination(ip net.IP) bool {
+	if ip == nil {
+		return !a.hasAllowedDestinations
+	}
+	return allow(a.destinations, a.hasAllowedDestinations, ip)
+}
+
+func allow(tree *cidrTree, hasAllowList bool, ip net.IP) bool {
+	allowed, matched := tree.lookup(ip)
+	if matched {
+		return allowed
+	}
+	// No explicit rule matched: allow by default, unless an allow
+	// list was configured, in which case it behaves as an allow-list.
+	return !hasAllowList
+}
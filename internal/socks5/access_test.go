@@ -0,0 +1,124 @@
+package socks5
+
+import (
+	"net"
+	"testing"
+
+	"github.com/qdm12/gluetun/internal/configuration/settings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_cidrTree_longestPrefixWins(t *testing.T) {
+	t.Parallel()
+
+	tree := newCIDRTree()
+	_, wide, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+	_, narrow, err := net.ParseCIDR("192.0.2.128/25")
+	require.NoError(t, err)
+	tree.insert(wide, false)
+	tree.insert(narrow, true)
+
+	allow, matched := tree.lookup(net.ParseIP("192.0.2.200"))
+	assert.True(t, matched)
+	assert.True(t, allow)
+
+	allow, matched = tree.lookup(net.ParseIP("192.0.2.50"))
+	assert.True(t, matched)
+	assert.False(t, allow)
+}
+
+func Test_cidrTree_denyWinsOnTie(t *testing.T) {
+	t.Parallel()
+
+	tree := newCIDRTree()
+	_, network, err := net.ParseCIDR("198.51.100.0/24")
+	require.NoError(t, err)
+	tree.insert(network, true)
+	tree.insert(network, false)
+
+	allow, matched := tree.lookup(net.ParseIP("198.51.100.1"))
+	assert.True(t, matched)
+	assert.False(t, allow)
+}
+
+func Test_cidrTree_noMatch(t *testing.T) {
+	t.Parallel()
+
+	tree := newCIDRTree()
+	_, matched := tree.lookup(net.ParseIP("203.0.113.1"))
+	assert.False(t, matched)
+}
+
+func Test_accessControl_domainDestination(t *testing.T) {
+	t.Parallel()
+
+	// A domain-name destination (ATYP domain) has no IP to match
+	// against the CIDR tree, so it falls back to the "no rule matched"
+	// behaviour instead of panicking. "t.co" and "trackingsite.com" are
+	// chosen for their byte lengths (4 and 16) to make sure ATYP, not
+	// addr length, decides whether addr is treated as a domain.
+	access, err := newAccessControl(settings.Socks5Access{})
+	require.NoError(t, err)
+	assert.True(t, access.allowDestination(destinationIP(atypDomain, []byte("t.co"))))
+	assert.True(t, access.allowDestination(destinationIP(atypDomain, []byte("trackingsite.com"))))
+
+	access, err = newAccessControl(settings.Socks5Access{AllowedDestinations: []string{"192.0.2.0/24"}})
+	require.NoError(t, err)
+	assert.False(t, access.allowDestination(destinationIP(atypDomain, []byte("t.co"))))
+	assert.False(t, access.allowDestination(destinationIP(atypDomain, []byte("trackingsite.com"))))
+}
+
+func Test_accessControl(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		access settings.Socks5Access
+		ip     string
+		client bool
+		allow  bool
+	}{
+		"no rules allows everything": {
+			ip:     "192.0.2.1",
+			client: true,
+			allow:  true,
+		},
+		"allow list rejects unlisted client": {
+			access: settings.Socks5Access{AllowedClients: []string{"192.0.2.0/24"}},
+			ip:     "203.0.113.1",
+			client: true,
+			allow:  false,
+		},
+		"allow list accepts listed client": {
+			access: settings.Socks5Access{AllowedClients: []string{"192.0.2.0/24"}},
+			ip:     "192.0.2.1",
+			client: true,
+			allow:  true,
+		},
+		"deny list rejects destination": {
+			access: settings.Socks5Access{DeniedDestinations: []string{"10.0.0.0/8"}},
+			ip:     "10.1.2.3",
+			client: false,
+			allow:  false,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			access, err := newAccessControl(testCase.access)
+			require.NoError(t, err)
+
+			ip := net.ParseIP(testCase.ip)
+			var allowed bool
+			if testCase.client {
+				allowed = access.allowClient(ip)
+			} else {
+				allowed = access.allowDestination(ip)
+			}
+			assert.Equal(t, testCase.allow, allowed)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package socks5
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// connectionEvent describes one completed Socks5 session for the
+// access log.
+type connectionEvent struct {
+	Timestamp time.Time     `json:"timestamp"`
+	ClientIP  string        `json:"client_ip"`
+	User      string        `json:"user,omitempty"`
+	Command   string        `json:"command"`
+	Target    string        `json:"target"`
+	BytesIn   int64         `json:"bytes_in"`
+	BytesOut  int64         `json:"bytes_out"`
+	Duration  time.Duration `json:"duration"`
+	Reply     string        `json:"reply"`
+}
+
+func (e connectionEvent) text() string {
+	return fmt.Sprintf(
+		"time=%s client=%s user=%s command=%s target=%s bytes_in=%d bytes_out=%d duration=%s reply=%s",
+		e.Timestamp.Format(time.RFC3339), e.ClientIP, e.User, e.Command, e.Target,
+		e.BytesIn, e.BytesOut, e.Duration, e.Reply)
+}
+
+func (e connectionEvent) json() string {
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return e.text()
+	}
+	return string(encoded)
+}
+
+// accessLogger emits one line per completed Socks5 session to the
+// server logger, and optionally to an additional sink writer.
+type accessLogger struct {
+	enabled bool
+	format  string
+	logger  infoErrorer
+	sink    io.Writer
+}
+
+func newAccessLogger(enabled bool, format string, logger infoErrorer, sink io.Writer) *accessLogger {
+	return &accessLogger{
+		enabled: enabled,
+		format:  format,
+		logger:  logger,
+		sink:    sink,
+	}
+}
+
+func (l *accessLogger) log(event connectionEvent) {
+	if !l.enabled {
+		return
+	}
+
+	line := event.text()
+	if l.format == "json" {
+		line = event.json()
+	}
+
+	l.logger.Info(line)
+	if l.sink != nil {
+		_, _ = io.WriteString(l.sink, line+"\n")
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	return tcpAddr.IP
+}
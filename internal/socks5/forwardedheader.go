@@ -0,0 +1,135 @@
+package socks5
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	xRealIPPrefix       = "X-Real-IP:"
+	forwardedPrefix     = "Forwarded:"
+	maxHeaderLineLength = 256
+)
+
+// trustedProxyListener rewrites the RemoteAddr of connections coming
+// from a trusted front-end proxy, using an X-Real-IP or Forwarded
+// preamble line tunneled by that proxy over the raw TCP stream ahead
+// of the Socks5 negotiation. Connections from any other source are
+// passed through untouched.
+type trustedProxyListener struct {
+	net.Listener
+	trustedProxies []*net.IPNet
+}
+
+func newTrustedProxyListener(inner net.Listener, trustedProxies []*net.IPNet) net.Listener {
+	return &trustedProxyListener{Listener: inner, trustedProxies: trustedProxies}
+}
+
+func (l *trustedProxyListener) Accept() (conn net.Conn, err error) {
+	conn, err = l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peerAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !ipInNetworks(peerAddr.IP, l.trustedProxies) {
+		return conn, nil
+	}
+
+	wrapped, err := wrapForwardedHeaderConn(conn, peerAddr)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("parsing forwarded header: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+func ipInNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func wrapForwardedHeaderConn(conn net.Conn, peerAddr *net.TCPAddr) (wrapped net.Conn, err error) {
+	reader := bufio.NewReader(conn)
+
+	peeked, err := reader.Peek(len(xRealIPPrefix))
+	if err == nil && string(peeked) == xRealIPPrefix {
+		ip, err := readForwardedLine(reader, xRealIPPrefix, parseXRealIP)
+		if err != nil {
+			return nil, err
+		}
+		return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: &net.TCPAddr{IP: ip, Port: peerAddr.Port}}, nil
+	}
+
+	peeked, err = reader.Peek(len(forwardedPrefix))
+	if err == nil && string(peeked) == forwardedPrefix {
+		ip, err := readForwardedLine(reader, forwardedPrefix, parseForwardedFor)
+		if err != nil {
+			return nil, err
+		}
+		return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: &net.TCPAddr{IP: ip, Port: peerAddr.Port}}, nil
+	}
+
+	// No cooperating header: keep the buffered reader so the bytes
+	// already peeked are not lost, but report the real peer address.
+	return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: peerAddr}, nil
+}
+
+func readForwardedLine(reader *bufio.Reader, prefix string, parse func(value string) (net.IP, error)) (ip net.IP, err error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading header line: %s", ErrProxyProtocolHeader, err)
+	}
+	if len(line) > maxHeaderLineLength {
+		return nil, fmt.Errorf("%w: header line too long", ErrProxyProtocolHeader)
+	}
+
+	value := strings.TrimPrefix(line, prefix)
+	value = strings.TrimSuffix(value, "\n")
+	value = strings.TrimSuffix(value, "\r")
+	value = strings.TrimSpace(value)
+
+	ip, err = parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProxyProtocolHeader, err)
+	}
+	return ip, nil
+}
+
+func parseXRealIP(value string) (ip net.IP, err error) {
+	ip = net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid X-Real-IP address %q", value)
+	}
+	return ip, nil
+}
+
+func parseForwardedFor(value string) (ip net.IP, err error) {
+	const forKey = "for="
+	for _, pair := range strings.Split(value, ";") {
+		pair = strings.TrimSpace(pair)
+		if !strings.HasPrefix(strings.ToLower(pair), forKey) {
+			continue
+		}
+		raw := pair[len(forKey):]
+		raw = strings.Trim(raw, `"`)
+		raw = strings.TrimPrefix(raw, "[")
+		if host, _, err := net.SplitHostPort(raw); err == nil {
+			raw = host
+		}
+		raw = strings.TrimSuffix(raw, "]")
+		ip = net.ParseIP(raw)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid Forwarded for= address %q", raw)
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("no for= token found in Forwarded header %q", value)
+}
@@ -0,0 +1,113 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_wrapForwardedHeaderConn_XRealIPWinsOverForwarded(t *testing.T) {
+	t.Parallel()
+
+	payload := "X-Real-IP: 203.0.113.7\nForwarded: for=198.51.100.2\n"
+	conn := &fakeConn{reader: bytes.NewBufferString(payload)}
+	peerAddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+
+	wrapped, err := wrapForwardedHeaderConn(conn, peerAddr)
+	require.NoError(t, err)
+
+	tcpAddr, ok := wrapped.RemoteAddr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.Equal(t, "203.0.113.7", tcpAddr.IP.String())
+}
+
+func Test_wrapForwardedHeaderConn_Forwarded(t *testing.T) {
+	t.Parallel()
+
+	payload := "Forwarded: for=198.51.100.2;proto=http\npayload"
+	conn := &fakeConn{reader: bytes.NewBufferString(payload)}
+	peerAddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+
+	wrapped, err := wrapForwardedHeaderConn(conn, peerAddr)
+	require.NoError(t, err)
+
+	tcpAddr, ok := wrapped.RemoteAddr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.Equal(t, "198.51.100.2", tcpAddr.IP.String())
+
+	remaining := make([]byte, len("payload"))
+	n, err := wrapped.Read(remaining)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(remaining[:n]))
+}
+
+func Test_ipInNetworks(t *testing.T) {
+	t.Parallel()
+
+	_, trusted, err := net.ParseCIDR("10.0.0.0/24")
+	require.NoError(t, err)
+
+	assert.False(t, ipInNetworks(net.ParseIP("203.0.113.1"), []*net.IPNet{trusted}))
+	assert.True(t, ipInNetworks(net.ParseIP("10.0.0.5"), []*net.IPNet{trusted}))
+}
+
+// Test_trustedProxyListener_spoofedHeaderFromUntrustedPeerIsIgnored drives
+// trustedProxyListener.Accept end to end: a peer outside TrustedProxies
+// sends a forged X-Real-IP preamble, and the listener must hand back the
+// connection untouched, keeping the real peer address and leaving the
+// forged line on the wire rather than acting on it.
+func Test_trustedProxyListener_spoofedHeaderFromUntrustedPeerIsIgnored(t *testing.T) {
+	t.Parallel()
+
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer raw.Close()
+
+	// No network the dialing peer (loopback) belongs to is trusted.
+	_, untrusted, err := net.ParseCIDR("203.0.113.0/24")
+	require.NoError(t, err)
+	listener := newTrustedProxyListener(raw, []*net.IPNet{untrusted})
+
+	accepted := make(chan net.Conn, 1)
+	acceptErrs := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			acceptErrs <- err
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	const spoofed = "X-Real-IP: 203.0.113.7\npayload"
+	_, err = client.Write([]byte(spoofed))
+	require.NoError(t, err)
+
+	var conn net.Conn
+	select {
+	case conn = <-accepted:
+	case err := <-acceptErrs:
+		t.Fatalf("accept failed: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for accepted connection")
+	}
+	defer conn.Close()
+
+	tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	require.True(t, ok)
+	assert.True(t, tcpAddr.IP.IsLoopback(), "spoofed X-Real-IP must be ignored for an untrusted peer")
+
+	received := make([]byte, len(spoofed))
+	_, err = io.ReadFull(conn, received)
+	require.NoError(t, err)
+	assert.Equal(t, spoofed, string(received), "the header line must be left untouched on the wire for untrusted peers")
+}
@@ -0,0 +1,262 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/txthinking/socks5"
+)
+
+const (
+	cmdConnect      = 0x01
+	cmdBind         = 0x02
+	cmdUDPAssociate = 0x03
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	repSucceeded           = 0x00
+	repNotAllowedByRuleset = 0x02
+	repHostUnreachable     = 0x04
+	repCommandNotSupported = 0x07
+)
+
+// accessHandler wraps the default socks5.Handler: it rejects clients
+// and destinations that are not allowed by the configured access
+// control rules, relays CONNECT sessions itself to count bytes
+// in/out, and emits one access log event per session. BIND and UDP
+// ASSOCIATE commands are delegated to the default handler after the
+// access checks, since the vendored socks5 library does not expose
+// their relayed bytes to a wrapping handler.
+type accessHandler struct {
+	socks5.Handler
+	access       *accessControl
+	log          *accessLogger
+	usernameFunc func() string
+}
+
+func newAccessHandler(access *accessControl, log *accessLogger, usernameFunc func() string) *accessHandler {
+	return &accessHandler{
+		Handler:      &socks5.DefaultHandle{},
+		access:       access,
+		log:          log,
+		usernameFunc: usernameFunc,
+	}
+}
+
+func (h *accessHandler) TCPHandle(server *socks5.Server, conn *net.TCPConn, request *socks5.Request) (err error) {
+	return h.handle(server, conn, request)
+}
+
+// serveWrapped drives the negotiation and request parsing that
+// ListenAndServe would normally do, for a connection coming from a
+// listener ListenAndServe cannot be handed directly (PROXY protocol or
+// trusted proxy header wrapping). Negotiate and GetRequest already
+// reply on error, so the connection is simply closed afterwards.
+func (h *accessHandler) serveWrapped(server *socks5.Server, conn net.Conn) {
+	defer conn.Close()
+
+	if err := server.Negotiate(conn); err != nil {
+		return
+	}
+	request, err := server.GetRequest(conn)
+	if err != nil {
+		return
+	}
+	_ = h.handle(server, conn, request)
+}
+
+func (h *accessHandler) handle(server *socks5.Server, conn net.Conn, request *socks5.Request) (err error) {
+	event := connectionEvent{
+		Timestamp: time.Now(),
+		ClientIP:  ipString(addrIP(conn.RemoteAddr())),
+		User:      h.usernameFunc(),
+		Command:   commandString(request.Cmd),
+		Target:    destinationString(request.Atyp, request.DstAddr, request.DstPort),
+	}
+
+	clientIP := addrIP(conn.RemoteAddr())
+	if clientIP == nil || !h.access.allowClient(clientIP) {
+		event.Reply = "denied: client not allowed"
+		h.log.log(event)
+		_ = writeReply(conn, repNotAllowedByRuleset)
+		return conn.Close()
+	}
+
+	if request.Cmd != cmdConnect {
+		// BIND and UDP ASSOCIATE don't carry a real destination in this
+		// request: for UDP ASSOCIATE in particular, DstAddr/DstPort is
+		// the address the client will send datagrams from (typically
+		// 0.0.0.0:0 per RFC1928 section 4), not a target to check
+		// against AllowedDestinations. The real per-datagram
+		// destination is checked in UDPHandle instead.
+		tcpConn, ok := conn.(*net.TCPConn)
+		if !ok {
+			// The vendored Handler interface only accepts a genuine
+			// *net.TCPConn for BIND/UDP ASSOCIATE, so a connection
+			// wrapped for PROXY protocol or a forwarded header can't
+			// be delegated to it.
+			event.Reply = "denied: command not supported over a wrapped connection"
+			h.log.log(event)
+			_ = writeReply(conn, repCommandNotSupported)
+			return conn.Close()
+		}
+		err = h.Handler.TCPHandle(server, tcpConn, request)
+		event.Duration = time.Since(event.Timestamp)
+		event.Reply = replyString(err)
+		h.log.log(event)
+		return err
+	}
+
+	if !h.access.allowDestination(destinationIP(request.Atyp, request.DstAddr)) {
+		event.Reply = "denied: destination not allowed"
+		h.log.log(event)
+		_ = writeReply(conn, repNotAllowedByRuleset)
+		return conn.Close()
+	}
+
+	return h.handleConnect(conn, event)
+}
+
+func (h *accessHandler) handleConnect(conn net.Conn, event connectionEvent) (err error) {
+	upstream, err := net.Dial("tcp", event.Target)
+	if err != nil {
+		event.Reply = "host unreachable"
+		event.Duration = time.Since(event.Timestamp)
+		h.log.log(event)
+		_ = writeReply(conn, repHostUnreachable)
+		return conn.Close()
+	}
+	defer upstream.Close()
+
+	if err := writeReply(conn, repSucceeded); err != nil {
+		event.Reply = "failed writing reply"
+		event.Duration = time.Since(event.Timestamp)
+		h.log.log(event)
+		return err
+	}
+
+	var bytesIn, bytesOut int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		bytesOut, _ = io.Copy(upstream, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		bytesIn, _ = io.Copy(conn, upstream)
+	}()
+	wg.Wait()
+
+	event.BytesIn = bytesIn
+	event.BytesOut = bytesOut
+	event.Duration = time.Since(event.Timestamp)
+	event.Reply = "succeeded"
+	h.log.log(event)
+
+	return nil
+}
+
+func (h *accessHandler) UDPHandle(server *socks5.Server, addr *net.UDPAddr, datagram *socks5.Datagram) (err error) {
+	event := connectionEvent{
+		Timestamp: time.Now(),
+		ClientIP:  ipString(addr.IP),
+		User:      h.usernameFunc(),
+		Command:   "UDP ASSOCIATE",
+		Target:    destinationString(datagram.Atyp, datagram.DstAddr, datagram.DstPort),
+		BytesOut:  int64(len(datagram.Data)),
+	}
+
+	if !h.access.allowClient(addr.IP) {
+		event.Reply = "denied: client not allowed"
+		h.log.log(event)
+		return nil
+	}
+
+	if !h.access.allowDestination(destinationIP(datagram.Atyp, datagram.DstAddr)) {
+		event.Reply = "denied: destination not allowed"
+		h.log.log(event)
+		return nil
+	}
+
+	err = h.Handler.UDPHandle(server, addr, datagram)
+	event.Duration = time.Since(event.Timestamp)
+	event.Reply = replyString(err)
+	h.log.log(event)
+
+	return err
+}
+
+func writeReply(conn net.Conn, rep byte) error {
+	reply := []byte{0x05, rep, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0}
+	_, err := conn.Write(reply)
+	return err
+}
+
+// destinationIP returns the IP address carried by addr, using atyp to
+// tell an IPv4/IPv6 address apart from an ATYP domain request, whose
+// addr instead holds the raw hostname bytes and has no IP to extract.
+func destinationIP(atyp byte, addr []byte) net.IP {
+	if atyp == atypDomain {
+		return nil
+	}
+	return net.IP(addr)
+}
+
+// destinationString returns addr:port as a string usable both for
+// logging and as the literal net.Dial address in handleConnect. For
+// ATYP domain, addr carries the hostname with its one-byte length
+// prefix still attached (see socks5.NewRequestFrom), so the literal
+// hostname is used instead of treating addr as an IP address.
+func destinationString(atyp byte, addr, port []byte) string {
+	const portLength = 2
+	host := hostString(atyp, addr)
+	if len(port) < portLength {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
+
+func hostString(atyp byte, addr []byte) string {
+	if atyp != atypDomain {
+		return net.IP(addr).String()
+	}
+	const domainLengthPrefix = 1
+	if len(addr) < domainLengthPrefix {
+		return ""
+	}
+	return string(addr[domainLengthPrefix:])
+}
+
+func commandString(cmd byte) string {
+	switch cmd {
+	case cmdConnect:
+		return "CONNECT"
+	case cmdBind:
+		return "BIND"
+	case cmdUDPAssociate:
+		return "UDP ASSOCIATE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func replyString(err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "succeeded"
+}
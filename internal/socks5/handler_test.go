@@ -0,0 +1,135 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/qdm12/gluetun/internal/configuration/settings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type noopInfoErrorer struct{}
+
+func (noopInfoErrorer) Info(string)  {}
+func (noopInfoErrorer) Error(string) {}
+
+func Test_destinationIP(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, net.IP(net.ParseIP("192.0.2.1").To4()), destinationIP(atypIPv4, net.ParseIP("192.0.2.1").To4()))
+	assert.NotNil(t, destinationIP(atypIPv6, net.ParseIP("2001:db8::1").To16()))
+	assert.Nil(t, destinationIP(atypDomain, []byte("example.com")))
+	// A domain name whose length happens to match a raw IPv4/IPv6
+	// address (4 or 16 bytes) must still be treated as a domain: only
+	// atyp decides, never the byte length of addr.
+	assert.Nil(t, destinationIP(atypDomain, []byte("t.co")))
+	assert.Nil(t, destinationIP(atypDomain, []byte("trackingsite.com")))
+}
+
+func Test_destinationString(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "192.0.2.1:443", destinationString(atypIPv4,
+		net.ParseIP("192.0.2.1").To4(), []byte{0x01, 0xbb}))
+	assert.Equal(t, "[2001:db8::1]:443", destinationString(atypIPv6,
+		net.ParseIP("2001:db8::1").To16(), []byte{0x01, 0xbb}))
+
+	// For ATYP domain, addr carries the hostname with its one-byte
+	// length prefix still attached, as produced by
+	// socks5.NewRequestFrom: the literal hostname must be recovered,
+	// not treated as a raw IP address.
+	domain := append([]byte{byte(len("example.com"))}, []byte("example.com")...)
+	assert.Equal(t, "example.com:443", destinationString(atypDomain, domain, []byte{0x01, 0xbb}))
+}
+
+func Test_accessHandler_logsLiveUsername(t *testing.T) {
+	t.Parallel()
+
+	access, err := newAccessControl(settings.Socks5Access{})
+	require.NoError(t, err)
+
+	username := "alice"
+	var sink bytes.Buffer
+	log := newAccessLogger(true, "text", noopInfoErrorer{}, &sink)
+	handler := newAccessHandler(access, log, func() string { return username })
+
+	// The username is read through usernameFunc on every event instead
+	// of being captured once at construction time, so a later
+	// credential rotation is reflected without rebuilding the handler.
+	handler.log.log(connectionEvent{User: handler.usernameFunc(), Command: "CONNECT"})
+	username = "bob"
+	handler.log.log(connectionEvent{User: handler.usernameFunc(), Command: "CONNECT"})
+
+	lines := sink.String()
+	assert.Contains(t, lines, "user=alice")
+	assert.Contains(t, lines, "user=bob")
+}
+
+func Test_accessHandler_handleConnect(t *testing.T) {
+	t.Parallel()
+
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	const echoedPayload = "hello upstream"
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buffer := make([]byte, len(echoedPayload))
+		_, _ = io.ReadFull(conn, buffer)
+		_, _ = conn.Write(buffer)
+	}()
+
+	access, err := newAccessControl(settings.Socks5Access{})
+	require.NoError(t, err)
+
+	var sink bytes.Buffer
+	log := newAccessLogger(true, "text", noopInfoErrorer{}, &sink)
+	handler := newAccessHandler(access, log, func() string { return "alice" })
+
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+
+	event := connectionEvent{
+		Timestamp: time.Now(),
+		ClientIP:  "198.51.100.1",
+		User:      "alice",
+		Command:   "CONNECT",
+		Target:    upstream.Addr().String(),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = handler.handleConnect(serverConn, event)
+	}()
+
+	reply := make([]byte, 10) //nolint:mnd
+	_, err = io.ReadFull(clientConn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, byte(0x00), reply[1])
+
+	_, err = clientConn.Write([]byte(echoedPayload))
+	require.NoError(t, err)
+
+	received := make([]byte, len(echoedPayload))
+	_, err = io.ReadFull(clientConn, received)
+	require.NoError(t, err)
+	assert.Equal(t, echoedPayload, string(received))
+
+	clientConn.Close()
+	<-done
+
+	logLines := sink.String()
+	assert.Contains(t, logLines, "reply=succeeded")
+	assert.Contains(t, logLines, "bytes_in=")
+	assert.Contains(t, logLines, "user=alice")
+}
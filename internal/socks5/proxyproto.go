@@ -0,0 +1,192 @@
+package socks5
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var ErrProxyProtocolHeader = errors.New("invalid PROXY protocol header")
+
+var (
+	proxyProtocolV1Prefix = []byte("PROXY ")
+	proxyProtocolV2Magic  = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// proxyProtocolListener wraps a net.Listener and parses a PROXY protocol
+// v1 or v2 header from the start of each accepted connection before
+// handing it to the caller, so the reported RemoteAddr is the original
+// client address rather than the upstream proxy's address.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+func newProxyProtocolListener(inner net.Listener) net.Listener {
+	return &proxyProtocolListener{Listener: inner}
+}
+
+func (l *proxyProtocolListener) Accept() (conn net.Conn, err error) {
+	conn, err = l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapProxyProtocolConn(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("parsing PROXY protocol header: %w", err)
+	}
+
+	return wrapped, nil
+}
+
+// proxyProtocolConn replays any bytes buffered while looking for the
+// PROXY protocol header and reports the original client address parsed
+// from that header.
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (n int, err error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func wrapProxyProtocolConn(conn net.Conn) (wrapped net.Conn, err error) {
+	reader := bufio.NewReader(conn)
+
+	peeked, err := reader.Peek(len(proxyProtocolV2Magic))
+	if err == nil && bytes.Equal(peeked, proxyProtocolV2Magic) {
+		remoteAddr, err := parseProxyProtocolV2(reader)
+		if err != nil {
+			return nil, err
+		}
+		return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: remoteAddr}, nil
+	}
+
+	peeked, err = reader.Peek(len(proxyProtocolV1Prefix))
+	if err == nil && bytes.Equal(peeked, proxyProtocolV1Prefix) {
+		remoteAddr, err := parseProxyProtocolV1(reader)
+		if err != nil {
+			return nil, err
+		}
+		return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: remoteAddr}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unrecognized signature", ErrProxyProtocolHeader)
+}
+
+func parseProxyProtocolV1(reader *bufio.Reader) (remoteAddr net.Addr, err error) {
+	const maxHeaderLength = 107
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading v1 header line: %s", ErrProxyProtocolHeader, err)
+	}
+	if len(line) > maxHeaderLength {
+		return nil, fmt.Errorf("%w: v1 header line too long", ErrProxyProtocolHeader)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+
+	fields := strings.Split(line, " ")
+	const fieldsCount = 6 // PROXY proto srcIP dstIP srcPort dstPort
+	if len(fields) != fieldsCount {
+		return nil, fmt.Errorf("%w: expected %d fields in v1 header, got %d",
+			ErrProxyProtocolHeader, fieldsCount, len(fields))
+	}
+
+	protocol := fields[1]
+	if protocol == "UNKNOWN" {
+		return nil, fmt.Errorf("%w: UNKNOWN protocol in v1 header", ErrProxyProtocolHeader)
+	} else if protocol != "TCP4" && protocol != "TCP6" {
+		return nil, fmt.Errorf("%w: unsupported protocol %q in v1 header", ErrProxyProtocolHeader, protocol)
+	}
+
+	srcIP := net.ParseIP(fields[2])
+	if srcIP == nil {
+		return nil, fmt.Errorf("%w: invalid source IP %q in v1 header", ErrProxyProtocolHeader, fields[2])
+	}
+
+	srcPort, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid source port %q in v1 header", ErrProxyProtocolHeader, fields[4])
+	}
+
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, nil
+}
+
+func parseProxyProtocolV2(reader *bufio.Reader) (remoteAddr net.Addr, err error) {
+	header := make([]byte, len(proxyProtocolV2Magic)+4)
+	if _, err := readFull(reader, header); err != nil {
+		return nil, fmt.Errorf("%w: reading v2 header: %s", ErrProxyProtocolHeader, err)
+	}
+
+	versionCommand := header[12]
+	const supportedVersion = 0x20
+	if versionCommand&0xF0 != supportedVersion {
+		return nil, fmt.Errorf("%w: unsupported v2 version %#x", ErrProxyProtocolHeader, versionCommand&0xF0)
+	}
+	command := versionCommand & 0x0F
+
+	familyTransport := header[13]
+	family := familyTransport & 0xF0
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	addresses := make([]byte, length)
+	if _, err := readFull(reader, addresses); err != nil {
+		return nil, fmt.Errorf("%w: reading v2 address block: %s", ErrProxyProtocolHeader, err)
+	}
+
+	const commandLocal = 0x00
+	if command == commandLocal {
+		// LOCAL command: the connection was established by the proxy
+		// itself (e.g. health check) and carries no address to parse.
+		return nil, fmt.Errorf("%w: LOCAL command carries no client address", ErrProxyProtocolHeader)
+	}
+
+	const (
+		familyIPv4 = 0x10
+		familyIPv6 = 0x20
+	)
+	switch family {
+	case familyIPv4:
+		const addrLength = 4 + 4 + 2 + 2
+		if len(addresses) < addrLength {
+			return nil, fmt.Errorf("%w: v2 IPv4 address block too short", ErrProxyProtocolHeader)
+		}
+		srcIP := net.IPv4(addresses[0], addresses[1], addresses[2], addresses[3])
+		srcPort := binary.BigEndian.Uint16(addresses[8:10])
+		return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, nil
+	case familyIPv6:
+		const addrLength = 16 + 16 + 2 + 2
+		if len(addresses) < addrLength {
+			return nil, fmt.Errorf("%w: v2 IPv6 address block too short", ErrProxyProtocolHeader)
+		}
+		srcIP := net.IP(addresses[0:16])
+		srcPort := binary.BigEndian.Uint16(addresses[32:34])
+		return &net.TCPAddr{IP: srcIP, Port: int(srcPort)}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported v2 address family %#x", ErrProxyProtocolHeader, family)
+	}
+}
+
+func readFull(reader *bufio.Reader, buffer []byte) (n int, err error) {
+	for n < len(buffer) {
+		read, err := reader.Read(buffer[n:])
+		n += read
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
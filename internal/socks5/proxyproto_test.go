@@ -0,0 +1,81 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *fakeConn) Read(b []byte) (n int, err error) {
+	return c.reader.Read(b)
+}
+
+func Test_wrapProxyProtocolConn_V1(t *testing.T) {
+	t.Parallel()
+
+	header := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+	payload := "hello"
+	conn := &fakeConn{reader: bytes.NewBufferString(header + payload)}
+
+	wrapped, err := wrapProxyProtocolConn(conn)
+	require.NoError(t, err)
+
+	assert.Equal(t, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}, wrapped.RemoteAddr())
+
+	remaining := make([]byte, len(payload))
+	n, err := wrapped.Read(remaining)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(remaining[:n]))
+}
+
+func Test_wrapProxyProtocolConn_V2(t *testing.T) {
+	t.Parallel()
+
+	var buffer bytes.Buffer
+	buffer.Write(proxyProtocolV2Magic)
+	buffer.WriteByte(0x21) // version 2, command PROXY
+	buffer.WriteByte(0x11) // family IPv4, transport STREAM
+
+	addresses := make([]byte, 4+4+2+2)
+	copy(addresses[0:4], net.ParseIP("192.0.2.1").To4())
+	copy(addresses[4:8], net.ParseIP("198.51.100.1").To4())
+	binary.BigEndian.PutUint16(addresses[8:10], 56324)
+	binary.BigEndian.PutUint16(addresses[10:12], 443)
+
+	lengthBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(lengthBytes, uint16(len(addresses)))
+	buffer.Write(lengthBytes)
+	buffer.Write(addresses)
+	buffer.WriteString("hello")
+
+	conn := &fakeConn{reader: &buffer}
+
+	wrapped, err := wrapProxyProtocolConn(conn)
+	require.NoError(t, err)
+
+	assert.Equal(t, &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}, wrapped.RemoteAddr())
+
+	remaining := make([]byte, len("hello"))
+	n, err := wrapped.Read(remaining)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(remaining[:n]))
+}
+
+func Test_wrapProxyProtocolConn_unrecognized(t *testing.T) {
+	t.Parallel()
+
+	conn := &fakeConn{reader: bytes.NewBufferString("GET / HTTP/1.1\r\n")}
+
+	_, err := wrapProxyProtocolConn(conn)
+	assert.ErrorIs(t, err, ErrProxyProtocolHeader)
+}
@@ -2,32 +2,54 @@ package socks5
 
 import (
 	"context"
+	"errors"
+	"github.com/qdm12/gluetun/internal/configuration/settings"
+	"github.com/txthinking/socks5"
+	"net"
 	"sync"
 	"time"
-	"github.com/txthinking/socks5"
 )
 
+// ErrServerNotRunning is returned by UpdateCredentials when the Socks5
+// server has not been started yet, or has already been shut down.
+var ErrServerNotRunning = errors.New("socks5 server is not running")
+
 type Server struct {
-	address           string
-	username 					string
-	password 					string
+	address  string
+	username string
+	password string
 	// handler           http.Handler
-	logger            infoErrorer
-	internalWG        *sync.WaitGroup
+	logger     infoErrorer
+	internalWG *sync.WaitGroup
 	// readHeaderTimeout time.Duration
-	readTimeout       time.Duration
+	readTimeout         time.Duration
+	acceptProxyProtocol bool
+	access              settings.Socks5Access
+	logConnections      bool
+	logFormat           string
+	trustedProxies      []string
+
+	classicServer   *socks5.Server
+	classicServerMu sync.Mutex
 }
 
 func New(ctx context.Context, address string, logger Logger,
 	username, password string, readTimeout time.Duration,
+	acceptProxyProtocol bool, access settings.Socks5Access,
+	logConnections bool, logFormat string, trustedProxies []string,
 ) *Server {
 	wg := &sync.WaitGroup{}
 	return &Server{
-		address:           address,
-		username: 				 username,
-		password: 				 password,
-		logger:            logger,
-		internalWG:        wg,
+		address:             address,
+		username:            username,
+		password:            password,
+		logger:              logger,
+		internalWG:          wg,
+		acceptProxyProtocol: acceptProxyProtocol,
+		access:              access,
+		logConnections:      logConnections,
+		logFormat:           logFormat,
+		trustedProxies:      trustedProxies,
 	}
 }
 
@@ -39,17 +61,46 @@ func (s *Server) Run(ctx context.Context, errorCh chan<- error) {
 		return
 	}
 
+	trustedProxyNets, err := parseCIDRs(s.trustedProxies)
+	if err != nil {
+		s.logger.Error("failed parsing trusted proxies: " + err.Error())
+		errorCh <- err
+		return
+	}
+
+	access, err := newAccessControl(s.access)
+	if err != nil {
+		s.logger.Error("failed creating socks5 access control: " + err.Error())
+		errorCh <- err
+		return
+	}
+	accessLog := newAccessLogger(s.logConnections, s.logFormat, s.logger, nil)
+	handler := newAccessHandler(access, accessLog, s.currentUsername)
+	server.Handle = handler
+
+	s.classicServerMu.Lock()
+	s.classicServer = server
+	s.classicServerMu.Unlock()
+	defer func() {
+		s.classicServerMu.Lock()
+		s.classicServer = nil
+		s.classicServerMu.Unlock()
+	}()
+
+	s.logger.Info("listening on " + s.address)
+
+	if s.acceptProxyProtocol || len(trustedProxyNets) > 0 {
+		s.runWithWrappedListener(ctx, server, handler, trustedProxyNets, errorCh)
+		return
+	}
+
 	go func() {
 		<-ctx.Done()
-		const shutdownGraceDuration = 100 * time.Millisecond
-		//shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGraceDuration)
-		// defer cancel()
 		if err := server.Shutdown(); err != nil {
 			s.logger.Error("failed shutting down: " + err.Error())
 		}
 	}()
-	s.logger.Info("listening on " + s.address)
-	err = server.ListenAndServe(nil) // nil means default handler
+	err = server.ListenAndServe(handler)
 	server.RunnerGroup.Wait()
 
 	if err != nil && ctx.Err() == nil {
@@ -58,3 +109,85 @@ func (s *Server) Run(ctx context.Context, errorCh chan<- error) {
 		errorCh <- nil
 	}
 }
+
+// runWithWrappedListener drives the SOCKS5 negotiation and request
+// dispatch itself over a listener wrapped for PROXY protocol or
+// trusted proxy header support. ClassicServer.ListenAndServe binds its
+// own net.Listener internally and has no way to be handed a wrapped
+// one, so this bypasses it and calls server.Negotiate/server.GetRequest
+// directly for every accepted connection instead. BIND and UDP
+// ASSOCIATE are only delegated to the default handler when the
+// accepted connection is still a genuine *net.TCPConn, see
+// accessHandler.handle.
+func (s *Server) runWithWrappedListener(ctx context.Context, server *socks5.Server,
+	handler *accessHandler, trustedProxyNets []*net.IPNet, errorCh chan<- error,
+) {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		s.logger.Error("failed listening: " + err.Error())
+		errorCh <- err
+		return
+	}
+	if len(trustedProxyNets) > 0 {
+		listener = newTrustedProxyListener(listener, trustedProxyNets)
+	}
+	if s.acceptProxyProtocol {
+		listener = newProxyProtocolListener(listener)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				errorCh <- nil
+			} else {
+				s.logger.Error("failed accepting connection: " + err.Error())
+				errorCh <- err
+			}
+			return
+		}
+		go handler.serveWrapped(server, conn)
+	}
+}
+
+// currentUsername returns the username currently configured on the
+// server, reflecting any UpdateCredentials call made after Run
+// started. It is used by the access handler so access log entries
+// keep showing the live username across a credential rotation.
+func (s *Server) currentUsername() string {
+	s.classicServerMu.Lock()
+	defer s.classicServerMu.Unlock()
+	return s.username
+}
+
+// UpdateCredentials mutates the user/password of the currently
+// running Socks5 server in place, so existing connections survive a
+// credential rotation. It returns ErrServerNotRunning if the server
+// has not been started yet.
+func (s *Server) UpdateCredentials(username, password string) error {
+	s.classicServerMu.Lock()
+	defer s.classicServerMu.Unlock()
+
+	if s.classicServer == nil {
+		return ErrServerNotRunning
+	}
+
+	s.username = username
+	s.password = password
+	s.classicServer.UserName = []byte(username)
+	s.classicServer.Password = []byte(password)
+	// Match the auth method NewClassicServer picks, so switching between
+	// anonymous and authenticated also applies to new sessions.
+	if username != "" && password != "" {
+		s.classicServer.Method = socks5.MethodUsernamePassword
+	} else {
+		s.classicServer.Method = socks5.MethodNone
+	}
+
+	return nil
+}
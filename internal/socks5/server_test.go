@@ -0,0 +1,182 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/qdm12/gluetun/internal/configuration/settings"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Server_UpdateCredentials_notRunning(t *testing.T) {
+	t.Parallel()
+
+	server := &Server{}
+	err := server.UpdateCredentials("alice", "secret")
+	assert.ErrorIs(t, err, ErrServerNotRunning)
+}
+
+// Test_Server_UpdateCredentials_rotatesLivePassword starts a real
+// Server, opens a long-lived authenticated session, rotates the
+// password in place and checks that the long-lived session keeps
+// relaying data while new sessions need the new password.
+func Test_Server_UpdateCredentials_rotatesLivePassword(t *testing.T) {
+	t.Parallel()
+
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}(conn)
+		}
+	}()
+
+	address := reserveFreeAddress(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := New(ctx, address, noopInfoErrorer{}, "alice", "secret",
+		time.Second, false, settings.Socks5Access{}, false, "text", nil)
+
+	errCh := make(chan error, 1)
+	go server.Run(ctx, errCh)
+	waitForListening(t, address)
+
+	longLived, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer longLived.Close()
+
+	require.NoError(t, socks5Negotiate(longLived, "alice", "secret"))
+	require.NoError(t, socks5Connect(longLived, upstream.Addr().String()))
+
+	require.NoError(t, server.UpdateCredentials("alice", "rotated"))
+
+	const probe = "still alive"
+	_, err = longLived.Write([]byte(probe))
+	require.NoError(t, err)
+	echoed := make([]byte, len(probe))
+	_, err = io.ReadFull(longLived, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, probe, string(echoed), "the already-authenticated session must survive the credential rotation")
+
+	oldCredsConn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer oldCredsConn.Close()
+	assert.Error(t, socks5Negotiate(oldCredsConn, "alice", "secret"), "the old password must be rejected once rotated")
+
+	newCredsConn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer newCredsConn.Close()
+	assert.NoError(t, socks5Negotiate(newCredsConn, "alice", "rotated"), "a new session must accept the rotated password")
+}
+
+func reserveFreeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return address
+}
+
+func waitForListening(t *testing.T, address string) {
+	t.Helper()
+	const timeout = 2 * time.Second
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			_ = conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("server did not start listening on %s", address)
+}
+
+// socks5Negotiate performs the RFC1928 method negotiation and the
+// RFC1929 username/password subnegotiation, returning an error if the
+// server rejects the credentials.
+func socks5Negotiate(conn net.Conn, user, pass string) error {
+	const methodUserPass = 0x02
+	if _, err := conn.Write([]byte{0x05, 0x01, methodUserPass}); err != nil {
+		return err
+	}
+
+	selection := make([]byte, 2) //nolint:mnd
+	if _, err := io.ReadFull(conn, selection); err != nil {
+		return err
+	}
+	if selection[1] != methodUserPass {
+		return fmt.Errorf("server did not select username/password auth: %x", selection[1])
+	}
+
+	request := []byte{0x01, byte(len(user))}
+	request = append(request, user...)
+	request = append(request, byte(len(pass)))
+	request = append(request, pass...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	status := make([]byte, 2) //nolint:mnd
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return err
+	}
+	if status[1] != 0x00 {
+		return fmt.Errorf("authentication rejected: status %x", status[1])
+	}
+	return nil
+}
+
+// socks5Connect issues a CONNECT request for targetAddr over an
+// already-negotiated connection and returns an error if it is refused.
+func socks5Connect(conn net.Conn, targetAddr string) error {
+	host, portString, err := net.SplitHostPort(targetAddr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return err
+	}
+	ip4 := net.ParseIP(host).To4()
+	if ip4 == nil {
+		return fmt.Errorf("target %s is not an IPv4 address", host)
+	}
+
+	const atypIPv4 = 0x01
+	request := []byte{0x05, cmdConnect, 0x00, atypIPv4}
+	request = append(request, ip4...)
+	portBytes := make([]byte, 2) //nolint:mnd
+	binary.BigEndian.PutUint16(portBytes, uint16(port))
+	request = append(request, portBytes...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 10) //nolint:mnd
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != repSucceeded {
+		return fmt.Errorf("connect rejected: reply %x", reply[1])
+	}
+	return nil
+}
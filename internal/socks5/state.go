@@ -2,27 +2,29 @@ package socks5
 
 import (
 	"context"
-	"sync"
 	"reflect"
+	"sync"
 
 	"github.com/qdm12/gluetun/internal/configuration/settings"
-	"github.com/qdm12/gluetun/internal/models"
 	"github.com/qdm12/gluetun/internal/constants"
+	"github.com/qdm12/gluetun/internal/models"
 )
 
-func NewState(statusApplier StatusApplier,
+func NewState(statusApplier StatusApplier, credentialsUpdater CredentialsUpdater,
 	settings settings.Socks5,
 ) *State {
 	return &State{
-		statusApplier: statusApplier,
-		settings:      settings,
+		statusApplier:      statusApplier,
+		credentialsUpdater: credentialsUpdater,
+		settings:           settings,
 	}
 }
 
 type State struct {
-	statusApplier StatusApplier
-	settings      settings.Socks5
-	settingsMu    sync.RWMutex
+	statusApplier      StatusApplier
+	credentialsUpdater CredentialsUpdater
+	settings           settings.Socks5
+	settingsMu         sync.RWMutex
 }
 
 type StatusApplier interface {
@@ -30,6 +32,12 @@ type StatusApplier interface {
 		outcome string, err error)
 }
 
+// CredentialsUpdater updates the user/password of a running Socks5
+// server in place, without dropping existing connections.
+type CredentialsUpdater interface {
+	UpdateCredentials(username, password string) error
+}
+
 func (s *State) GetSettings() (settings settings.Socks5) {
 	s.settingsMu.RLock()
 	defer s.settingsMu.RUnlock()
@@ -45,10 +53,20 @@ func (s *State) SetSettings(ctx context.Context,
 		s.settingsMu.Unlock()
 		return "settings left unchanged"
 	}
+	previousSettings := s.settings
 	newEnabled := *settings.Enabled
-	previousEnabled := *s.settings.Enabled
+	previousEnabled := *previousSettings.Enabled
 	s.settings = settings
 	s.settingsMu.Unlock()
+
+	if newEnabled && previousEnabled && onlyCredentialsChanged(previousSettings, settings) {
+		err := s.credentialsUpdater.UpdateCredentials(*settings.User, *settings.Password)
+		if err != nil {
+			return "failed updating credentials: " + err.Error()
+		}
+		return "credentials updated"
+	}
+
 	// Either restart or set changed status
 	switch {
 	case !newEnabled && !previousEnabled:
@@ -62,3 +80,15 @@ func (s *State) SetSettings(ctx context.Context,
 	}
 	return "settings updated"
 }
+
+// onlyCredentialsChanged returns true if a and b only differ in their
+// User and Password fields, which can be applied to a running Socks5
+// server without restarting its listener. ReadTimeout is not in this
+// set, so a ReadTimeout change goes through the restart path below.
+// Server does not use the read timeout it is given, so the new value
+// has no effect after that restart either.
+func onlyCredentialsChanged(a, b settings.Socks5) bool {
+	a.User = b.User
+	a.Password = b.Password
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,63 @@
+package socks5
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qdm12/gluetun/internal/configuration/settings"
+	"github.com/stretchr/testify/assert"
+)
+
+func ptrTo[T any](value T) *T { return &value }
+
+func Test_onlyCredentialsChanged(t *testing.T) {
+	t.Parallel()
+
+	base := settings.Socks5{
+		User:             ptrTo("alice"),
+		Password:         ptrTo("secret"),
+		ListeningAddress: "0.0.0.0:1080",
+		Enabled:          ptrTo(true),
+		ReadTimeout:      3 * time.Second,
+	}
+
+	testCases := map[string]struct {
+		mutate func(s *settings.Socks5)
+		want   bool
+	}{
+		"only password changed": {
+			mutate: func(s *settings.Socks5) { s.Password = ptrTo("newpass") },
+			want:   true,
+		},
+		"only user changed": {
+			mutate: func(s *settings.Socks5) { s.User = ptrTo("bob") },
+			want:   true,
+		},
+		"listening address changed": {
+			mutate: func(s *settings.Socks5) { s.ListeningAddress = "0.0.0.0:1081" },
+			want:   false,
+		},
+		// ReadTimeout cannot be applied to a running server by
+		// CredentialsUpdater, so a change to it must not be treated as
+		// a hot-reloadable credentials change, even alongside a real
+		// credentials change.
+		"read timeout changed alongside credentials": {
+			mutate: func(s *settings.Socks5) {
+				s.User = ptrTo("bob")
+				s.ReadTimeout = 5 * time.Second
+			},
+			want: false,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			other := base
+			testCase.mutate(&other)
+
+			assert.Equal(t, testCase.want, onlyCredentialsChanged(base, other))
+		})
+	}
+}